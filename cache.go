@@ -0,0 +1,77 @@
+package realip
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultCacheSize is the number of trustedIP results kept in memory
+// when Builder.CacheSize has not been called.
+const defaultCacheSize = 4096
+
+// lruCache is a fixed-size, least-recently-used cache mapping an IP
+// string to whether it was found to be trusted. Capping it bounds the
+// memory a hostile upstream can make the Handler allocate by sending an
+// unbounded stream of distinct (possibly spoofed) IPs in a header walked
+// in recursive mode.
+//
+// A capacity of 0 or less disables caching: every Get misses and every
+// Put is a no-op.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	trusted bool
+}
+
+func newLRUCache(capacity int) *lruCache {
+	if capacity <= 0 {
+		return &lruCache{}
+	}
+	return &lruCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element, capacity),
+	}
+}
+
+func (c *lruCache) Get(key string) (trusted, ok bool) {
+	if c.capacity <= 0 {
+		return false, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, found := c.items[key]
+	if !found {
+		return false, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*cacheEntry).trusted, true
+}
+
+func (c *lruCache) Put(key string, trusted bool) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, found := c.items[key]; found {
+		el.Value.(*cacheEntry).trusted = trusted
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, trusted: trusted})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,28 @@
+package realip
+
+import "testing"
+
+func TestLRUCacheEviction(t *testing.T) {
+	c := newLRUCache(2)
+	c.Put("1.1.1.1", true)
+	c.Put("2.2.2.2", false)
+	c.Put("3.3.3.3", true) // evicts 1.1.1.1, the least recently used
+
+	if _, ok := c.Get("1.1.1.1"); ok {
+		t.Fatal("expected 1.1.1.1 to have been evicted")
+	}
+	if trusted, ok := c.Get("2.2.2.2"); !ok || trusted {
+		t.Fatalf("got trusted=%v ok=%v, expect false,true", trusted, ok)
+	}
+	if trusted, ok := c.Get("3.3.3.3"); !ok || !trusted {
+		t.Fatalf("got trusted=%v ok=%v, expect true,true", trusted, ok)
+	}
+}
+
+func TestLRUCacheDisabled(t *testing.T) {
+	c := newLRUCache(0)
+	c.Put("1.1.1.1", true)
+	if _, ok := c.Get("1.1.1.1"); ok {
+		t.Fatal("expected caching to be disabled")
+	}
+}
@@ -1,19 +1,19 @@
 package realip
 
 import (
-	"errors"
+	"context"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
 )
 
 // Popular Headers
 const (
 	HeaderXForwardedFor = "x-forwarded-for"
 	HeaderXRealIP       = "x-real-ip"
+	HeaderForwarded     = "forwarded"
 	// we can also specify True-Client-IP, CF-Connecting-IP and so on to c.RealIPHeader
-	headerForwarded = "forwarded"
 )
 
 // Handler is the main object that acts as a middleware much like
@@ -22,13 +22,37 @@ const (
 // Handlers must be constructed using the `Builder`. Using the zero
 // value will result in undefined behavior.
 type Handler struct {
-	trusted   []*net.IPNet
-	srcHeader string // default: X-Real-IP. where to get the real IP from
-	dstHeader string // default: X-Real-IP. where to set the replacement IP to
-	recursive bool
-	next      http.Handler
-	muCache   *sync.RWMutex
-	cache     map[string]struct{}
+	trusted               []*net.IPNet
+	trustedIndex          *cidrTrie // built from trusted at Build time, for O(bits) containment checks
+	srcHeaders            []string  // default: [X-Real-IP]. headers to try, in order, for the real IP
+	dstHeader             string    // default: X-Real-IP. where to set the replacement IP to
+	recursive             bool
+	strategy              Strategy
+	privateRanges         []*net.IPNet
+	disableHeaderMutation bool
+	rejectInvalid         bool
+	cacheSize             int
+	next                  http.Handler
+	cache                 *lruCache
+}
+
+// contextKey is the type used as a context.Context key for storing the
+// resolved client IP, so it can't collide with keys from other packages.
+type contextKey struct{}
+
+// ContextKey is the context.Context key under which Handler.ServeHTTP
+// stashes the resolved client IP as a net.IP. Prefer FromContext over
+// looking it up with this key directly.
+var ContextKey = contextKey{}
+
+// FromContext returns the client IP that Handler.ServeHTTP resolved for
+// this request, along with whether one was found. This is an
+// alternative to re-parsing the destination header in handlers that want
+// a net.IP value, and is the only way to retrieve it when the Handler
+// was built with DisableHeaderMutation(true).
+func FromContext(ctx context.Context) (net.IP, bool) {
+	ip, ok := ctx.Value(ContextKey).(net.IP)
+	return ip, ok
 }
 
 // Builder is used to construct a realip.Handler object.
@@ -55,38 +79,56 @@ func (b *Builder) Build() (*Handler, error) {
 	if err != nil {
 		return nil, err
 	}
+	if h.strategy == nil {
+		h.strategy = &legacyHeaderStrategy{h: h}
+	}
+	if h.privateRanges != nil {
+		h.strategy = applyPrivateRanges(h.strategy, h.privateRanges)
+	}
+	if len(h.trusted) > 0 {
+		h.trustedIndex = newCIDRTrie(h.trusted)
+	}
+	h.cache = newLRUCache(h.cacheSize)
 	return h, nil
 }
 
 func (b *Builder) Reset() *Builder {
 	b.err = nil
 	b.h = &Handler{
-		srcHeader: HeaderXRealIP,
-		dstHeader: HeaderXRealIP,
-		cache:     make(map[string]struct{}),
-		muCache:   &sync.RWMutex{},
+		srcHeaders: []string{HeaderXRealIP},
+		dstHeader:  HeaderXRealIP,
+		cacheSize:  defaultCacheSize,
 	}
 	return b
 }
 
-// SourceHeader sets the header field to read the real IP from.
-// Because this module does not supported the `Forwarded` header yet,
-// specifying `Forwarded` will result in the builder failing to build
-// a realip.Handler
+// SourceHeader sets the single header field to read the real IP from.
+// In addition to single-value headers such as `X-Real-IP`, the
+// `X-Forwarded-For` and `Forwarded` (RFC 7239) headers are recognized
+// and parsed as a list of hops. This is a shortcut for
+// `SourceHeaders(name)`.
 //
 // This is equivalent to `real_ip_header` directive in
 // ngx_http_realip_module.
 func (b *Builder) SourceHeader(name string) *Builder {
+	return b.SourceHeaders(name)
+}
+
+// SourceHeaders sets an ordered list of candidate header fields to read
+// the real IP from, for deployments fronted by multiple layers (e.g.
+// Cloudflare then an internal load balancer) that each set their own
+// header. ServeHTTP tries each header in turn and uses the first one
+// that yields a valid IP, applying the list-walk/Recursive logic for
+// X-Forwarded-For and Forwarded and a plain lookup for everything else.
+func (b *Builder) SourceHeaders(names ...string) *Builder {
 	if b.err != nil {
 		return b
 	}
-	lowered := strings.ToLower(strings.TrimSpace(name))
-	if lowered == headerForwarded {
-		b.err = errors.New("realip.Builder: `Forwarded` header is not supported")
-		return b
+	headers := make([]string, len(names))
+	for i, name := range names {
+		headers[i] = strings.ToLower(strings.TrimSpace(name))
 	}
-
-	b.h.srcHeader = lowered
+	b.h.srcHeaders = headers
 	return b
 }
 
@@ -125,6 +167,74 @@ func (b *Builder) Recursive(v bool) *Builder {
 	return b
 }
 
+// Strategy overrides the built-in header handling (SourceHeader,
+// TrustedIP, Recursive) with the given Strategy (or strategies, tried in
+// order as a ChainStrategy) for computing the client IP once the remote
+// address has passed the trusted-CIDR gate. This allows composing
+// stock strategies such as SingleHeaderStrategy,
+// RightmostNonPrivateStrategy, TrustedForwardingStrategy, and
+// RemoteAddrStrategy, e.g. to try CF-Connecting-IP, then a trusted-CIDR
+// X-Forwarded-For walk, then fall back to RemoteAddr.
+func (b *Builder) Strategy(strategies ...Strategy) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if len(strategies) == 1 {
+		b.h.strategy = strategies[0]
+	} else {
+		b.h.strategy = ChainStrategy(strategies...)
+	}
+	return b
+}
+
+// PrivateRanges overrides the CIDR ranges that RightmostNonPrivateStrategy
+// and LeftmostNonPrivateStrategy, when used via Strategy, treat as
+// private and skip over. See DefaultPrivateRanges for the default set.
+func (b *Builder) PrivateRanges(ranges []*net.IPNet) *Builder {
+	if b.err != nil {
+		return b
+	}
+	b.h.privateRanges = ranges
+	return b
+}
+
+// DisableHeaderMutation, when enabled, stops ServeHTTP from writing the
+// resolved client IP to DestinationHeader, leaving FromContext as the
+// only way to retrieve it. This is useful for handlers that want to
+// leave the original header untouched (e.g. to log RemoteAddr as-is)
+// while still reading the derived client IP.
+func (b *Builder) DisableHeaderMutation(v bool) *Builder {
+	if b.err != nil {
+		return b
+	}
+	b.h.disableHeaderMutation = v
+	return b
+}
+
+// RejectInvalid, when enabled, makes the handler fall back to RemoteAddr
+// whenever the configured source header (or Strategy) is present but
+// doesn't resolve to a parseable IP address, instead of propagating the
+// invalid value verbatim.
+func (b *Builder) RejectInvalid(v bool) *Builder {
+	if b.err != nil {
+		return b
+	}
+	b.h.rejectInvalid = v
+	return b
+}
+
+// CacheSize sets how many trustedIP results (both positive and
+// negative) are kept in an LRU cache, bounding the memory a hostile or
+// high-cardinality upstream can make the Handler allocate. Defaults to
+// 4096; 0 disables the cache entirely.
+func (b *Builder) CacheSize(size int) *Builder {
+	if b.err != nil {
+		return b
+	}
+	b.h.cacheSize = size
+	return b
+}
+
 // Wrap returns a http.Handler that wraps the given handler with the
 // realip.Handler object, allowing you to use it as a middleware.
 //
@@ -139,28 +249,31 @@ func (h *Handler) Wrap(next http.Handler) http.Handler {
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rawRemoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
 	if !h.trustedIP(net.ParseIP(rawRemoteIP), h.trusted) {
-		if rawRemoteIP != "" {
+		if rawRemoteIP != "" && !h.disableHeaderMutation {
 			r.Header.Set(h.dstHeader, rawRemoteIP)
 		}
-		h.next.ServeHTTP(w, r)
+		h.next.ServeHTTP(w, h.withClientIPContext(r, rawRemoteIP))
 		return
 	}
 
-	var realIP string
-	switch h.srcHeader { // note: h.srcHeader is guaranteed to be lower cased
-	case HeaderXForwardedFor:
-		realIP = h.realIPFromXFF(r.Header.Get(HeaderXForwardedFor))
-	default:
-		realIP = r.Header.Get(h.srcHeader)
-	}
-
-	if realIP == "" {
+	realIP := h.strategy.ClientIP(r)
+	if realIP == "" || (h.rejectInvalid && net.ParseIP(realIP) == nil) {
 		realIP = rawRemoteIP
 	}
-	if realIP != "" {
+	if realIP != "" && !h.disableHeaderMutation {
 		r.Header.Set(h.dstHeader, realIP)
 	}
-	h.next.ServeHTTP(w, r)
+	h.next.ServeHTTP(w, h.withClientIPContext(r, realIP))
+}
+
+// withClientIPContext returns r with realIP stashed in its context under
+// ContextKey, or r unchanged if realIP doesn't parse as an IP address.
+func (h *Handler) withClientIPContext(r *http.Request, realIP string) *http.Request {
+	ip := net.ParseIP(realIP)
+	if ip == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), ContextKey, ip))
 }
 
 func (h *Handler) trustedIP(ip net.IP, trusted []*net.IPNet) bool {
@@ -170,22 +283,23 @@ func (h *Handler) trustedIP(ip net.IP, trusted []*net.IPNet) bool {
 	}
 
 	ipstr := ip.String()
-	h.muCache.RLock()
-	_, cached := h.cache[ipstr]
-	h.muCache.RUnlock()
-	if cached {
-		return true
+	if result, cached := h.cache.Get(ipstr); cached {
+		return result
 	}
 
-	for _, fromIP := range trusted {
-		if fromIP.Contains(ip) {
-			h.muCache.Lock()
-			h.cache[ipstr] = struct{}{}
-			h.muCache.Unlock()
-			return true
+	result := false
+	if h.trustedIndex != nil {
+		result = h.trustedIndex.Contains(ip)
+	} else {
+		for _, fromIP := range trusted {
+			if fromIP.Contains(ip) {
+				result = true
+				break
+			}
 		}
 	}
-	return false
+	h.cache.Put(ipstr, result)
+	return result
 }
 
 func (h *Handler) realIPFromXFF(xff string) string {
@@ -195,14 +309,163 @@ func (h *Handler) realIPFromXFF(xff string) string {
 	}
 
 	if !h.recursive {
-		return strings.TrimSpace(ips[len(ips)-1])
+		return validHopIP(ips[len(ips)-1])
 	}
 
 	for i := len(ips) - 1; i >= 0; i-- {
-		ipStr := strings.TrimSpace(ips[i])
+		ipStr := validHopIP(ips[i])
+		if ipStr == "" {
+			// malformed entry; skip it rather than trust or return it
+			continue
+		}
 		if !h.trustedIP(net.ParseIP(ipStr), h.trusted) {
 			return ipStr
 		}
 	}
-	return strings.TrimSpace(ips[0])
+	return validHopIP(ips[0])
+}
+
+// validHopIP trims, de-brackets, and strips the optional :port suffix
+// from a single XFF/Forwarded hop, per the MDN client-IP selection
+// algorithm, and returns its canonical form, or "" if it isn't a legal
+// IP address.
+func validHopIP(raw string) string {
+	ip := net.ParseIP(stripHopPort(strings.TrimSpace(raw)))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// realIPFromForwarded implements the same rightmost-non-trusted walk as
+// realIPFromXFF, but sourced from one or more RFC 7239 `Forwarded` header
+// values instead of `X-Forwarded-For`.
+func (h *Handler) realIPFromForwarded(values []string) string {
+	fors := parseForwardedFor(values)
+	if len(fors) == 0 {
+		return ""
+	}
+
+	if !h.recursive {
+		return validHopIP(fors[len(fors)-1])
+	}
+
+	for i := len(fors) - 1; i >= 0; i-- {
+		node := fors[i]
+		if isObfuscatedForwardedNode(node) {
+			// an obfuscated identifier is opaque, so we have no way to
+			// check it against the trusted CIDR list; stop here and
+			// report whatever real IP we already resolved to its right.
+			for j := i + 1; j < len(fors); j++ {
+				if ip := validHopIP(fors[j]); ip != "" {
+					return ip
+				}
+			}
+			return ""
+		}
+		ip := validHopIP(node)
+		if ip == "" {
+			// malformed entry; skip it rather than trust or return it
+			continue
+		}
+		if !h.trustedIP(net.ParseIP(ip), h.trusted) {
+			return ip
+		}
+	}
+	return validHopIP(fors[0])
+}
+
+// isObfuscatedForwardedNode reports whether s is an obfuscated identifier
+// as described in RFC 7239 section 6.3, e.g. `_hidden` or `unknown`.
+func isObfuscatedForwardedNode(s string) bool {
+	return strings.HasPrefix(s, "_") || strings.EqualFold(s, "unknown")
+}
+
+// parseForwardedFor extracts the ordered list of `for` node identifiers
+// from one or more `Forwarded` header values, handling multiple
+// comma-separated elements per value, quoted-string parameters, and
+// bracketed IPv6 literals such as `"[2001:db8::1]:4711"`.
+func parseForwardedFor(values []string) []string {
+	var fors []string
+	for _, value := range values {
+		for _, element := range splitForwardedUnquoted(value, ',') {
+			element = strings.TrimSpace(element)
+			if element == "" {
+				continue
+			}
+			for _, pair := range splitForwardedUnquoted(element, ';') {
+				key, val, ok := strings.Cut(pair, "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "for") {
+					continue
+				}
+				val = strings.TrimSpace(val)
+				val = stripForwardedQuotes(val)
+				val = stripHopPort(val)
+				fors = append(fors, val)
+			}
+		}
+	}
+	return fors
+}
+
+// splitForwardedUnquoted splits s on sep, ignoring occurrences of sep
+// inside a double-quoted string, per the RFC 7230 quoted-string grammar
+// used by RFC 7239 parameter values.
+func splitForwardedUnquoted(s string, sep byte) []string {
+	var parts []string
+	var buf strings.Builder
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && inQuotes && i+1 < len(s):
+			buf.WriteByte(c)
+			i++
+			buf.WriteByte(s[i])
+		case c == '"':
+			inQuotes = !inQuotes
+			buf.WriteByte(c)
+		case c == sep && !inQuotes:
+			parts = append(parts, buf.String())
+			buf.Reset()
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	parts = append(parts, buf.String())
+	return parts
+}
+
+// stripForwardedQuotes removes surrounding double quotes and unescapes
+// a quoted-string value, leaving unquoted values untouched.
+func stripForwardedQuotes(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	s = strings.ReplaceAll(s, `\"`, `"`)
+	s = strings.ReplaceAll(s, `\\`, `\`)
+	return s
+}
+
+// stripHopPort removes the optional `:port` suffix from a hop, and
+// unwraps the brackets around a bracketed IPv6 literal such as
+// `[2001:db8::1]:4711`. A bare (unbracketed) IPv6 literal has several
+// colons and is left untouched, since an unbracketed `:port` suffix is
+// only unambiguous for IPv4.
+func stripHopPort(s string) string {
+	if strings.HasPrefix(s, "[") {
+		if idx := strings.IndexByte(s, ']'); idx != -1 {
+			return s[1:idx]
+		}
+		return s
+	}
+	if strings.Count(s, ":") != 1 {
+		return s
+	}
+	idx := strings.IndexByte(s, ':')
+	if _, err := strconv.Atoi(s[idx+1:]); err == nil {
+		return s[:idx]
+	}
+	return s
 }
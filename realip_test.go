@@ -28,11 +28,6 @@ func TestMiddleware(t *testing.T) {
 		create  func() (*realip.Handler, error)
 		error   bool
 	}{
-		{
-			name:   "header `Forwarded` is not supported",
-			create: realip.New().SourceHeader("Forwarded").Build,
-			error:  true,
-		},
 		{
 			name:   "X-Real-IP: default",
 			expect: "127.0.0.1",
@@ -130,6 +125,154 @@ func TestMiddleware(t *testing.T) {
 				Recursive(true).
 				Build,
 		},
+		{
+			name: "forwarded: single element",
+			headers: map[string]string{
+				"Forwarded": `for=192.0.2.60;proto=http;by=203.0.113.43`,
+			},
+			expect: "192.0.2.60",
+			create: realip.New().SourceHeader(realip.HeaderForwarded).Build,
+		},
+		{
+			name: "forwarded: recent non-trusted one",
+			headers: map[string]string{
+				"Forwarded": `for=1.2.3.4, for=1.1.1.1, for=192.168.0.1`,
+			},
+			expect: "1.1.1.1",
+			create: realip.New().
+				SourceHeader(realip.HeaderForwarded).
+				TrustedIP(
+					mustParseCIDR("127.0.0.1/32"),
+					mustParseCIDR("192.168.0.0/16"),
+				).
+				Recursive(true).
+				Build,
+		},
+		{
+			name: "forwarded: quoted ipv6 literal with port",
+			headers: map[string]string{
+				"Forwarded": `for="[2001:db8::1]:4711", for=192.168.0.1`,
+			},
+			expect: "2001:db8::1",
+			create: realip.New().
+				SourceHeader(realip.HeaderForwarded).
+				TrustedIP(
+					mustParseCIDR("127.0.0.1/32"),
+					mustParseCIDR("192.168.0.0/16"),
+				).
+				Recursive(true).
+				Build,
+		},
+		{
+			name: "forwarded: obfuscated identifier terminates the recursive walk",
+			headers: map[string]string{
+				"Forwarded": `for=1.1.1.1, for=_hidden, for=192.168.0.1`,
+			},
+			expect: "192.168.0.1",
+			create: realip.New().
+				SourceHeader(realip.HeaderForwarded).
+				TrustedIP(
+					mustParseCIDR("127.0.0.1/32"),
+					mustParseCIDR("192.168.0.0/16"),
+				).
+				Recursive(true).
+				Build,
+		},
+		{
+			name: "x-forwarded-for: spoofed garbage is not propagated",
+			headers: map[string]string{
+				"X-Forwarded-For": "garbage",
+			},
+			expect: "127.0.0.1",
+			create: realip.New().
+				SourceHeader(realip.HeaderXForwardedFor).
+				TrustedIP(mustParseCIDR("127.0.0.1/32")).
+				Build,
+		},
+		{
+			name: "x-forwarded-for: malformed entries are skipped in recursive mode",
+			headers: map[string]string{
+				"X-Forwarded-For": "1.1.1.1, garbage, 192.168.0.1",
+			},
+			expect: "1.1.1.1",
+			create: realip.New().
+				SourceHeader(realip.HeaderXForwardedFor).
+				TrustedIP(
+					mustParseCIDR("127.0.0.1/32"),
+					mustParseCIDR("192.168.0.0/16"),
+				).
+				Recursive(true).
+				Build,
+		},
+		{
+			// SingleHeaderStrategy returns its header's value verbatim,
+			// unlike the built-in XFF/Forwarded/legacy-default paths,
+			// which already self-validate via net.ParseIP. This is the
+			// scenario RejectInvalid exists for.
+			name: "RejectInvalid: falls back to RemoteAddr for a non-validating Strategy",
+			headers: map[string]string{
+				"CF-Connecting-IP": "garbage",
+			},
+			expect: "127.0.0.1",
+			create: realip.New().
+				Strategy(realip.SingleHeaderStrategy("CF-Connecting-IP")).
+				RejectInvalid(true).
+				Build,
+		},
+		{
+			name: "SourceHeaders: falls through to the next header",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.9",
+			},
+			expect: "203.0.113.9",
+			create: realip.New().
+				SourceHeaders("True-Client-IP", realip.HeaderXForwardedFor, realip.HeaderXRealIP).
+				TrustedIP(mustParseCIDR("127.0.0.1/32")).
+				Build,
+		},
+		{
+			name: "SourceHeaders: prefers the first configured header present",
+			headers: map[string]string{
+				"True-Client-IP":  "198.51.100.2",
+				"X-Forwarded-For": "203.0.113.9",
+			},
+			expect: "198.51.100.2",
+			create: realip.New().
+				SourceHeaders("True-Client-IP", realip.HeaderXForwardedFor).
+				TrustedIP(mustParseCIDR("127.0.0.1/32")).
+				Build,
+		},
+		{
+			name: "CacheSize: 0 disables caching but trusted lookups still work",
+			headers: map[string]string{
+				"X-Forwarded-For": "192.168.0.1",
+			},
+			expect: "192.168.0.1",
+			create: realip.New().
+				SourceHeader(realip.HeaderXForwardedFor).
+				TrustedIP(mustParseCIDR("127.0.0.1/32")).
+				CacheSize(0).
+				Build,
+		},
+		{
+			name: "TrustedForwardingStrategy: composed in a ChainStrategy, falls through from CF-Connecting-IP",
+			headers: map[string]string{
+				"X-Forwarded-For": "1.1.1.1, 192.168.0.1",
+			},
+			expect: "1.1.1.1",
+			create: realip.New().
+				TrustedIP(mustParseCIDR("127.0.0.1/32")).
+				Strategy(realip.ChainStrategy(
+					realip.SingleHeaderStrategy("CF-Connecting-IP"),
+					realip.TrustedForwardingStrategy(
+						[]string{realip.HeaderXForwardedFor},
+						[]*net.IPNet{mustParseCIDR("192.168.0.0/16")},
+						true,
+					),
+					realip.RemoteAddrStrategy{},
+				)).
+				Build,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -168,3 +311,154 @@ func TestMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestFromContext(t *testing.T) {
+	h, err := realip.New().
+		SourceHeader(realip.HeaderXForwardedFor).
+		TrustedIP(mustParseCIDR("127.0.0.1/32")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotIP net.IP
+	var gotOK bool
+	handler := h.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotIP, gotOK = realip.FromContext(req.Context())
+	}))
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("X-Forwarded-For", "192.168.0.1")
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+
+	if !gotOK {
+		t.Fatal("expected a client IP in the request context")
+	}
+	if gotIP.String() != "192.168.0.1" {
+		t.Errorf("got: %s, expect: 192.168.0.1", gotIP)
+	}
+}
+
+func TestPrivateRangesDoesNotMutateSharedStrategy(t *testing.T) {
+	shared := realip.RightmostNonPrivateStrategy(realip.HeaderXForwardedFor)
+
+	h1, err := realip.New().
+		Strategy(shared).
+		PrivateRanges([]*net.IPNet{mustParseCIDR("203.0.113.0/24")}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := realip.New().Strategy(shared).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newServer := func(h *realip.Handler) *httptest.Server {
+		handler := h.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, req.Header.Get(realip.HeaderXRealIP))
+		}))
+		return httptest.NewServer(handler)
+	}
+	get := func(ts *httptest.Server) string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-Forwarded-For", "8.8.8.8, 203.0.113.9")
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		data, _ := io.ReadAll(r.Body)
+		return strings.TrimSpace(string(data))
+	}
+
+	ts1 := newServer(h1)
+	defer ts1.Close()
+	if out := get(ts1); out != "8.8.8.8" {
+		t.Errorf("h1 out: %s, expect: 8.8.8.8", out)
+	}
+
+	ts2 := newServer(h2)
+	defer ts2.Close()
+	if out := get(ts2); out != "203.0.113.9" {
+		t.Errorf("h2 out: %s, expect: 203.0.113.9 (h1's PrivateRanges must not leak into h2 via the shared Strategy)", out)
+	}
+}
+
+func TestForwardedMultipleHeaderInstances(t *testing.T) {
+	h, err := realip.New().
+		SourceHeader(realip.HeaderForwarded).
+		TrustedIP(
+			mustParseCIDR("127.0.0.1/32"),
+			mustParseCIDR("192.168.0.0/16"),
+		).
+		Recursive(true).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := h.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, req.Header.Get(realip.HeaderXRealIP))
+	}))
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	// RFC 7239 allows the client to send Forwarded as several separate
+	// header instances instead of one comma-separated value; both forms
+	// must parse to the same ordered hop list.
+	req.Header.Add("Forwarded", "for=1.1.1.1")
+	req.Header.Add("Forwarded", "for=192.168.0.1")
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+
+	data, _ := io.ReadAll(r.Body)
+	out := strings.TrimSpace(string(data))
+	expect := "1.1.1.1"
+	if out != expect {
+		t.Errorf("out: %s, expect: %s", out, expect)
+	}
+}
+
+func TestDisableHeaderMutation(t *testing.T) {
+	h, err := realip.New().
+		SourceHeader(realip.HeaderXForwardedFor).
+		TrustedIP(mustParseCIDR("127.0.0.1/32")).
+		DisableHeaderMutation(true).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := h.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip, _ := realip.FromContext(req.Context())
+		fmt.Fprintf(w, "header=%q context=%s", req.Header.Get(realip.HeaderXRealIP), ip)
+	}))
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("X-Forwarded-For", "192.168.0.1")
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+
+	data, _ := io.ReadAll(r.Body)
+	out := strings.TrimSpace(string(data))
+	expect := `header="" context=192.168.0.1`
+	if out != expect {
+		t.Errorf("out: %s, expect: %s", out, expect)
+	}
+}
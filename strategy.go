@@ -0,0 +1,275 @@
+package realip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Strategy computes the client IP for a given request. Strategies are
+// composed via Builder.Strategy, and are tried by ChainStrategy until one
+// returns a non-empty value.
+type Strategy interface {
+	ClientIP(r *http.Request) string
+}
+
+// RemoteAddrStrategy returns the IP portion of r.RemoteAddr, ignoring
+// any header. This is a sensible last resort in a ChainStrategy.
+type RemoteAddrStrategy struct{}
+
+func (RemoteAddrStrategy) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SingleHeaderStrategy returns a Strategy that reads the client IP
+// verbatim from a single-value header, such as X-Real-IP or
+// CF-Connecting-IP.
+func SingleHeaderStrategy(header string) Strategy {
+	return singleHeaderStrategy(strings.ToLower(strings.TrimSpace(header)))
+}
+
+type singleHeaderStrategy string
+
+func (s singleHeaderStrategy) ClientIP(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get(string(s)))
+}
+
+// nonPrivateStrategy implements RightmostNonPrivateStrategy and
+// LeftmostNonPrivateStrategy: it walks a comma-separated header (or, for
+// the Forwarded header, its `for` node list) from one end and returns
+// the first entry that does not fall within a private range.
+type nonPrivateStrategy struct {
+	header   string
+	leftmost bool
+	private  []*net.IPNet // set from Builder.PrivateRanges at Build time; nil: DefaultPrivateRanges() is used on each ClientIP call
+}
+
+// RightmostNonPrivateStrategy returns a Strategy that reads header as a
+// comma-separated list of hops (or, for the Forwarded header, its `for`
+// node list) and returns the rightmost entry that is not in a private
+// range, per the MDN-recommended selection algorithm. This is the
+// strategy to use when the list of trusted proxy CIDRs is unknown or
+// impractical to enumerate. See DefaultPrivateRanges for what counts as
+// private, and Builder.PrivateRanges to customize it.
+func RightmostNonPrivateStrategy(header string) Strategy {
+	return &nonPrivateStrategy{header: strings.ToLower(strings.TrimSpace(header))}
+}
+
+// LeftmostNonPrivateStrategy is like RightmostNonPrivateStrategy, but
+// walks the hop list from the left. This is only safe to use when every
+// hop that could have appended to the header is trusted not to spoof
+// entries ahead of the real client IP.
+func LeftmostNonPrivateStrategy(header string) Strategy {
+	return &nonPrivateStrategy{header: strings.ToLower(strings.TrimSpace(header)), leftmost: true}
+}
+
+func (s *nonPrivateStrategy) ClientIP(r *http.Request) string {
+	var hops []string
+	if s.header == HeaderForwarded {
+		hops = parseForwardedFor(r.Header.Values("Forwarded"))
+	} else {
+		raw := r.Header.Get(s.header)
+		if raw == "" {
+			return ""
+		}
+		for _, hop := range strings.Split(raw, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	ranges := s.private
+	if ranges == nil {
+		ranges = DefaultPrivateRanges()
+	}
+
+	if s.leftmost {
+		for _, hop := range hops {
+			if ip := nonPrivateIP(hop, ranges); ip != "" {
+				return ip
+			}
+		}
+		return ""
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if ip := nonPrivateIP(hops[i], ranges); ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// nonPrivateIP parses hop as an IP address, stripping brackets/port the
+// way Forwarded `for` nodes do, and returns its string form, or "" if it
+// doesn't parse or falls within one of ranges.
+func nonPrivateIP(hop string, ranges []*net.IPNet) string {
+	ip := net.ParseIP(stripHopPort(hop))
+	if ip == nil {
+		return ""
+	}
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return ""
+		}
+	}
+	return ip.String()
+}
+
+// ChainStrategy returns a Strategy that tries each of strategies in
+// order and returns the first non-empty result, e.g. to try
+// CF-Connecting-IP, then X-Forwarded-For, then RemoteAddr.
+func ChainStrategy(strategies ...Strategy) Strategy {
+	return chainStrategy(strategies)
+}
+
+type chainStrategy []Strategy
+
+func (c chainStrategy) ClientIP(r *http.Request) string {
+	for _, s := range c {
+		if s == nil {
+			continue
+		}
+		if ip := s.ClientIP(r); ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// TrustedForwardingStrategy returns a Strategy implementing the same
+// trusted-CIDR/recursive X-Forwarded-For and Forwarded handling that
+// Build installs by default when Builder.Strategy is never called, so
+// that it can be composed into an explicit ChainStrategy instead of only
+// being reachable as the implicit default, e.g. to try CF-Connecting-IP
+// first and fall back to a trusted-CIDR X-Forwarded-For walk:
+//
+//	realip.ChainStrategy(
+//		realip.SingleHeaderStrategy("CF-Connecting-IP"),
+//		realip.TrustedForwardingStrategy([]string{realip.HeaderXForwardedFor}, trustedCIDRs, true),
+//		realip.RemoteAddrStrategy{},
+//	)
+//
+// headers and trusted are normalized the same way Builder.SourceHeaders
+// and Builder.TrustedIP normalize theirs; recursive matches
+// Builder.Recursive.
+func TrustedForwardingStrategy(headers []string, trusted []*net.IPNet, recursive bool) Strategy {
+	normalized := make([]string, len(headers))
+	for i, name := range headers {
+		normalized[i] = strings.ToLower(strings.TrimSpace(name))
+	}
+
+	h := &Handler{
+		srcHeaders: normalized,
+		trusted:    trusted,
+		recursive:  recursive,
+		cache:      newLRUCache(defaultCacheSize),
+	}
+	if len(trusted) > 0 {
+		h.trustedIndex = newCIDRTrie(trusted)
+	}
+	return &legacyHeaderStrategy{h: h}
+}
+
+// legacyHeaderStrategy reproduces the original SourceHeader(s) /
+// X-Forwarded-For / Forwarded handling, consulting the Handler's
+// trusted-CIDR list in recursive mode. It is installed by Build as the
+// default strategy so that configurations built only from SourceHeader(s),
+// TrustedIP, and Recursive keep their existing behavior unchanged, and is
+// also reachable explicitly via TrustedForwardingStrategy for composing
+// into a ChainStrategy.
+type legacyHeaderStrategy struct {
+	h *Handler
+}
+
+func (s *legacyHeaderStrategy) ClientIP(r *http.Request) string {
+	for _, header := range s.h.srcHeaders { // note: each header is guaranteed to be lower cased
+		var candidate string
+		switch header {
+		case HeaderXForwardedFor:
+			candidate = s.h.realIPFromXFF(r.Header.Get(HeaderXForwardedFor))
+		case HeaderForwarded:
+			candidate = s.h.realIPFromForwarded(r.Header.Values("Forwarded"))
+		default:
+			candidate = strings.TrimSpace(r.Header.Get(header))
+			if candidate != "" && net.ParseIP(candidate) == nil {
+				candidate = ""
+			}
+		}
+		if candidate != "" {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// DefaultPrivateRanges returns the CIDR ranges treated as "private" (and
+// therefore skipped) by RightmostNonPrivateStrategy and
+// LeftmostNonPrivateStrategy when Builder.PrivateRanges has not been
+// used to override them: RFC 1918 and RFC 4193 private networks, the
+// loopback and link-local ranges, and the RFC 6598 CGNAT range.
+//
+// The CIDRs are parsed once and cached; the returned slice is shared
+// and must not be modified by callers.
+func DefaultPrivateRanges() []*net.IPNet {
+	defaultPrivateRangesOnce.Do(func() {
+		ranges := make([]*net.IPNet, len(defaultPrivateRangeCIDRs))
+		for i, cidr := range defaultPrivateRangeCIDRs {
+			_, ipnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				panic("realip: invalid default private range " + cidr)
+			}
+			ranges[i] = ipnet
+		}
+		defaultPrivateRanges = ranges
+	})
+	return defaultPrivateRanges
+}
+
+var (
+	defaultPrivateRangesOnce sync.Once
+	defaultPrivateRanges     []*net.IPNet
+)
+
+var defaultPrivateRangeCIDRs = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// applyPrivateRanges returns a Strategy equivalent to s, but with ranges
+// applied to every nonPrivateStrategy reachable from s that wasn't given
+// explicit ranges of its own. It never mutates s itself: a Strategy value
+// may be shared across several Builders, and stamping ranges into it in
+// place would leak one Handler's PrivateRanges into every other Handler
+// built from the same shared value (and race if both Build() calls
+// happen concurrently). Nodes that already have explicit ranges, or that
+// aren't a *nonPrivateStrategy/chainStrategy, are returned unchanged.
+func applyPrivateRanges(s Strategy, ranges []*net.IPNet) Strategy {
+	switch v := s.(type) {
+	case *nonPrivateStrategy:
+		if v.private != nil {
+			return v
+		}
+		clone := *v
+		clone.private = ranges
+		return &clone
+	case chainStrategy:
+		resolved := make(chainStrategy, len(v))
+		for i, child := range v {
+			resolved[i] = applyPrivateRanges(child, ranges)
+		}
+		return resolved
+	default:
+		return s
+	}
+}
@@ -0,0 +1,96 @@
+package realip_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lestrrat-go/realip"
+)
+
+func TestStrategy(t *testing.T) {
+	testCases := []struct {
+		name     string
+		strategy realip.Strategy
+		headers  map[string]string
+		remote   string
+		expect   string
+	}{
+		{
+			name:     "RemoteAddrStrategy",
+			strategy: realip.RemoteAddrStrategy{},
+			remote:   "203.0.113.7:1234",
+			expect:   "203.0.113.7",
+		},
+		{
+			name:     "SingleHeaderStrategy",
+			strategy: realip.SingleHeaderStrategy("CF-Connecting-IP"),
+			headers:  map[string]string{"CF-Connecting-IP": "203.0.113.9"},
+			remote:   "127.0.0.1:1234",
+			expect:   "203.0.113.9",
+		},
+		{
+			name:     "RightmostNonPrivateStrategy",
+			strategy: realip.RightmostNonPrivateStrategy(realip.HeaderXForwardedFor),
+			headers:  map[string]string{"X-Forwarded-For": "203.0.113.9, 10.0.0.1, 192.168.0.1"},
+			remote:   "127.0.0.1:1234",
+			expect:   "203.0.113.9",
+		},
+		{
+			name:     "LeftmostNonPrivateStrategy",
+			strategy: realip.LeftmostNonPrivateStrategy(realip.HeaderXForwardedFor),
+			headers:  map[string]string{"X-Forwarded-For": "10.0.0.1, 203.0.113.9, 192.168.0.1"},
+			remote:   "127.0.0.1:1234",
+			expect:   "203.0.113.9",
+		},
+		{
+			name: "ChainStrategy falls through to RemoteAddr",
+			strategy: realip.ChainStrategy(
+				realip.SingleHeaderStrategy("CF-Connecting-IP"),
+				realip.RightmostNonPrivateStrategy(realip.HeaderXForwardedFor),
+				realip.RemoteAddrStrategy{},
+			),
+			remote: "203.0.113.7:1234",
+			expect: "203.0.113.7",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remote
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			if got := tc.strategy.ClientIP(req); got != tc.expect {
+				t.Errorf("got: %s, expect: %s", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestBuilderStrategy(t *testing.T) {
+	h, err := realip.New().
+		Strategy(
+			realip.SingleHeaderStrategy("CF-Connecting-IP"),
+			realip.RightmostNonPrivateStrategy(realip.HeaderXForwardedFor),
+		).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, req.Header.Get(realip.HeaderXRealIP))
+	})
+	w := httptest.NewRecorder()
+	h.Wrap(echo).ServeHTTP(w, req)
+	if got := w.Body.String(); got != "203.0.113.9" {
+		t.Errorf("got: %s, expect: 203.0.113.9", got)
+	}
+}
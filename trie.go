@@ -0,0 +1,90 @@
+package realip
+
+import "net"
+
+// cidrTrie is an uncompressed binary radix trie over IP address bits,
+// built once from a list of trusted CIDRs so that containment checks
+// run in O(bits) instead of scanning every configured range. IPv4 and
+// IPv6 addresses are indexed in separate trees since they have
+// different bit widths.
+type cidrTrie struct {
+	root4 *trieNode
+	root6 *trieNode
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	terminal bool // a CIDR ends here: every address beneath is contained
+}
+
+// newCIDRTrie builds a cidrTrie from nets. Invalid entries are ignored.
+func newCIDRTrie(nets []*net.IPNet) *cidrTrie {
+	t := &cidrTrie{root4: &trieNode{}, root6: &trieNode{}}
+	for _, n := range nets {
+		t.insert(n)
+	}
+	return t
+}
+
+func (t *cidrTrie) insert(n *net.IPNet) {
+	if n == nil {
+		return
+	}
+
+	root, addr := t.rootAndAddr(n.IP)
+	if addr == nil {
+		return
+	}
+	ones, bits := n.Mask.Size()
+	if bits != len(addr)*8 {
+		// mask doesn't match the address family we resolved to
+		return
+	}
+
+	node := root
+	for i := 0; i < ones; i++ {
+		if node.terminal {
+			// a shorter prefix already covers this range
+			return
+		}
+		bit := bitAt(addr, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+	node.children = [2]*trieNode{} // prune now-redundant longer branches
+}
+
+// Contains reports whether ip falls under any of the CIDRs the trie was
+// built from.
+func (t *cidrTrie) Contains(ip net.IP) bool {
+	root, addr := t.rootAndAddr(ip)
+	if addr == nil {
+		return false
+	}
+
+	node := root
+	for i := 0; i < len(addr)*8; i++ {
+		if node.terminal {
+			return true
+		}
+		node = node.children[bitAt(addr, i)]
+		if node == nil {
+			return false
+		}
+	}
+	return node.terminal
+}
+
+func (t *cidrTrie) rootAndAddr(ip net.IP) (*trieNode, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return t.root4, ip4
+	}
+	return t.root6, ip.To16()
+}
+
+func bitAt(addr net.IP, i int) int {
+	return int(addr[i/8]>>(7-i%8)) & 1
+}
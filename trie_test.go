@@ -0,0 +1,40 @@
+package realip
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCIDRTrie(t *testing.T) {
+	trusted := []*net.IPNet{
+		mustParseCIDRInternal("192.168.0.0/16"),
+		mustParseCIDRInternal("10.0.0.0/8"),
+		mustParseCIDRInternal("2001:db8::/32"),
+	}
+	trie := newCIDRTrie(trusted)
+
+	testCases := []struct {
+		ip     string
+		expect bool
+	}{
+		{"192.168.1.1", true},
+		{"10.1.2.3", true},
+		{"8.8.8.8", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+
+	for _, tc := range testCases {
+		if got := trie.Contains(net.ParseIP(tc.ip)); got != tc.expect {
+			t.Errorf("Contains(%s) = %v, expect %v", tc.ip, got, tc.expect)
+		}
+	}
+}
+
+func mustParseCIDRInternal(addr string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		panic(err)
+	}
+	return ipnet
+}